@@ -0,0 +1,107 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+import "github.com/google/cayley/graph"
+
+// Count wraps another iterator and, rather than yielding its results,
+// yields a single value: the number of results that iterator has. It backs
+// Gremlin's .Count().
+type Count struct {
+	uid       uint64
+	tags      graph.Tagger
+	primaryIt graph.Iterator
+	result    int64
+	done      bool
+}
+
+// NewCount creates a new Count iterator, wrapping subIt.
+func NewCount(subIt graph.Iterator) *Count {
+	return &Count{
+		uid:       NextUID(),
+		primaryIt: subIt,
+	}
+}
+
+func (it *Count) UID() uint64 { return it.uid }
+
+func (it *Count) Reset() {
+	it.primaryIt.Reset()
+	it.done = false
+	it.result = 0
+}
+
+func (it *Count) Tagger() *graph.Tagger { return &it.tags }
+
+func (it *Count) TagResults(dst map[string]graph.Value) {
+	it.tags.TagResult(dst, it.Result())
+}
+
+func (it *Count) Clone() graph.Iterator {
+	out := NewCount(it.primaryIt.Clone())
+	out.tags.CopyFrom(it)
+	return out
+}
+
+func (it *Count) Next() bool {
+	if it.done {
+		return false
+	}
+	var n int64
+	for it.primaryIt.Next() {
+		n++
+		for it.primaryIt.NextPath() {
+			n++
+		}
+	}
+	it.result = n
+	it.done = true
+	return true
+}
+
+func (it *Count) Err() error { return it.primaryIt.Err() }
+
+func (it *Count) Result() graph.Value { return it.result }
+
+func (it *Count) NextPath() bool { return false }
+
+func (it *Count) Contains(graph.Value) bool { return false }
+
+func (it *Count) Close() error { return it.primaryIt.Close() }
+
+func (it *Count) Size() (int64, bool) { return 1, true }
+
+func (it *Count) Type() graph.Type { return graph.Count }
+
+func (it *Count) Optimize() (graph.Iterator, bool) { return it, false }
+
+func (it *Count) SubIterators() []graph.Iterator { return []graph.Iterator{it.primaryIt} }
+
+func (it *Count) Stats() graph.IteratorStats {
+	stats := it.primaryIt.Stats()
+	return graph.IteratorStats{
+		ContainsCost: stats.ContainsCost,
+		NextCost:     stats.NextCost,
+		Size:         1,
+	}
+}
+
+func (it *Count) Describe() graph.Description {
+	return graph.Description{
+		UID:      it.UID(),
+		Type:     it.Type(),
+		Iterator: it.primaryIt.Describe(),
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+import "github.com/google/cayley/graph"
+
+// GroupCountResult is the Value yielded for each row of a GroupCount: the
+// distinct name seen under the grouped tag, and how many times it was
+// seen.
+type GroupCountResult struct {
+	Name  string
+	Count int64
+}
+
+// GroupCount fully consumes another iterator, grouping its results by the
+// value tagged tag, and yields one GroupCountResult per distinct value.
+// Unlike Cayley's other iterators, GroupCount is not pull-through: the
+// first call to Next materializes every result of the wrapped iterator
+// before anything is yielded.
+type GroupCount struct {
+	uid       uint64
+	tags      graph.Tagger
+	primaryIt graph.Iterator
+	qs        graph.QuadStore
+	tag       string
+	rows      []GroupCountResult
+	rowTags   []map[string]graph.Value
+	index     int
+	ready     bool
+}
+
+// NewGroupCount creates a new GroupCount, wrapping subIt and grouping by
+// the value tagged tag.
+func NewGroupCount(subIt graph.Iterator, tag string, qs graph.QuadStore) *GroupCount {
+	return &GroupCount{
+		uid:       NextUID(),
+		primaryIt: subIt,
+		qs:        qs,
+		tag:       tag,
+		index:     -1,
+	}
+}
+
+func (it *GroupCount) UID() uint64 { return it.uid }
+
+func (it *GroupCount) Reset() {
+	it.primaryIt.Reset()
+	it.rows = nil
+	it.rowTags = nil
+	it.index = -1
+	it.ready = false
+}
+
+func (it *GroupCount) Tagger() *graph.Tagger { return &it.tags }
+
+func (it *GroupCount) TagResults(dst map[string]graph.Value) {
+	it.tags.TagResult(dst, it.Result())
+	if it.index >= 0 && it.index < len(it.rowTags) {
+		for k, v := range it.rowTags[it.index] {
+			dst[k] = v
+		}
+	}
+}
+
+func (it *GroupCount) Clone() graph.Iterator {
+	out := NewGroupCount(it.primaryIt.Clone(), it.tag, it.qs)
+	out.tags.CopyFrom(it)
+	return out
+}
+
+func (it *GroupCount) materialize() {
+	counts := make(map[string]int64)
+	tags := make(map[string]map[string]graph.Value)
+	for it.primaryIt.Next() {
+		it.count(counts, tags)
+		for it.primaryIt.NextPath() {
+			it.count(counts, tags)
+		}
+	}
+	rows := make([]GroupCountResult, 0, len(counts))
+	rowTags := make([]map[string]graph.Value, 0, len(counts))
+	for name, n := range counts {
+		rows = append(rows, GroupCountResult{Name: name, Count: n})
+		rowTags = append(rowTags, tags[name])
+	}
+	it.rows = rows
+	it.rowTags = rowTags
+	it.ready = true
+}
+
+// count tallies the current result under its tagged name, and, the
+// first time that name is seen, remembers the tags it carried -- so a
+// .Save() earlier in the chain survives into the grouped row. Later
+// occurrences of the same name may carry different tag values; there's
+// no single row to attribute them to, so they're dropped in favor of the
+// first.
+func (it *GroupCount) count(counts map[string]int64, tags map[string]map[string]graph.Value) {
+	dst := make(map[string]graph.Value)
+	it.primaryIt.TagResults(dst)
+	v, ok := dst[it.tag]
+	if !ok {
+		return
+	}
+	name := it.qs.NameOf(v)
+	counts[name]++
+	if _, seen := tags[name]; !seen {
+		tags[name] = dst
+	}
+}
+
+func (it *GroupCount) Next() bool {
+	if !it.ready {
+		it.materialize()
+	}
+	if it.index+1 >= len(it.rows) {
+		return false
+	}
+	it.index++
+	return true
+}
+
+func (it *GroupCount) Err() error { return it.primaryIt.Err() }
+
+func (it *GroupCount) Result() graph.Value {
+	if it.index < 0 || it.index >= len(it.rows) {
+		return nil
+	}
+	return it.rows[it.index]
+}
+
+func (it *GroupCount) NextPath() bool { return false }
+
+func (it *GroupCount) Contains(graph.Value) bool { return false }
+
+func (it *GroupCount) Close() error { return it.primaryIt.Close() }
+
+func (it *GroupCount) Size() (int64, bool) { return int64(len(it.rows)), it.ready }
+
+func (it *GroupCount) Type() graph.Type { return graph.GroupCount }
+
+func (it *GroupCount) Optimize() (graph.Iterator, bool) { return it, false }
+
+func (it *GroupCount) SubIterators() []graph.Iterator { return []graph.Iterator{it.primaryIt} }
+
+func (it *GroupCount) Stats() graph.IteratorStats {
+	stats := it.primaryIt.Stats()
+	return graph.IteratorStats{
+		ContainsCost: stats.ContainsCost,
+		NextCost:     stats.NextCost * 2,
+		Size:         stats.Size,
+	}
+}
+
+func (it *GroupCount) Describe() graph.Description {
+	return graph.Description{
+		UID:      it.UID(),
+		Type:     it.Type(),
+		Tags:     []string{it.tag},
+		Iterator: it.primaryIt.Describe(),
+	}
+}
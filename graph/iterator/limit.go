@@ -0,0 +1,107 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+import "github.com/google/cayley/graph"
+
+// Limit wraps another iterator and yields at most n of its results. A
+// non-positive n means unbounded, so it behaves as a pass-through.
+type Limit struct {
+	uid       uint64
+	tags      graph.Tagger
+	primaryIt graph.Iterator
+	limit     int64
+	count     int64
+}
+
+// NewLimit creates a new Limit iterator, wrapping subIt and stopping after
+// limit results (or never, if limit <= 0).
+func NewLimit(subIt graph.Iterator, limit int64) *Limit {
+	return &Limit{
+		uid:       NextUID(),
+		primaryIt: subIt,
+		limit:     limit,
+	}
+}
+
+func (it *Limit) UID() uint64 { return it.uid }
+
+func (it *Limit) Reset() {
+	it.primaryIt.Reset()
+	it.count = 0
+}
+
+func (it *Limit) Tagger() *graph.Tagger { return &it.tags }
+
+func (it *Limit) TagResults(dst map[string]graph.Value) {
+	it.tags.TagResult(dst, it.Result())
+	it.primaryIt.TagResults(dst)
+}
+
+func (it *Limit) Clone() graph.Iterator {
+	out := NewLimit(it.primaryIt.Clone(), it.limit)
+	out.tags.CopyFrom(it)
+	return out
+}
+
+func (it *Limit) Next() bool {
+	if it.limit > 0 && it.count >= it.limit {
+		return false
+	}
+	if !it.primaryIt.Next() {
+		return false
+	}
+	it.count++
+	return true
+}
+
+func (it *Limit) Err() error { return it.primaryIt.Err() }
+
+func (it *Limit) Result() graph.Value { return it.primaryIt.Result() }
+
+func (it *Limit) NextPath() bool {
+	if it.limit > 0 && it.count >= it.limit {
+		return false
+	}
+	return it.primaryIt.NextPath()
+}
+
+func (it *Limit) Contains(v graph.Value) bool { return it.primaryIt.Contains(v) }
+
+func (it *Limit) Close() error { return it.primaryIt.Close() }
+
+func (it *Limit) Size() (int64, bool) {
+	size, exact := it.primaryIt.Size()
+	if it.limit > 0 && (!exact || size > it.limit) {
+		return it.limit, exact
+	}
+	return size, exact
+}
+
+func (it *Limit) Type() graph.Type { return graph.Limit }
+
+func (it *Limit) Optimize() (graph.Iterator, bool) { return it, false }
+
+func (it *Limit) SubIterators() []graph.Iterator { return []graph.Iterator{it.primaryIt} }
+
+func (it *Limit) Stats() graph.IteratorStats { return it.primaryIt.Stats() }
+
+func (it *Limit) Describe() graph.Description {
+	return graph.Description{
+		UID:      it.UID(),
+		Type:     it.Type(),
+		Iterator: it.primaryIt.Describe(),
+	}
+}
@@ -0,0 +1,192 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+import "github.com/google/cayley/graph"
+
+// Morphism is a single step to repeat: given the QuadStore and an iterator
+// over the current frontier, it returns the iterator for the next layer.
+// It has the same shape as graph/path.Morphism, but Recursive can't import
+// that package without creating an import cycle.
+type Morphism func(qs graph.QuadStore, base graph.Iterator) graph.Iterator
+
+type recursiveResult struct {
+	Value graph.Value
+	Depth int
+}
+
+// Recursive computes the transitive closure of repeatedly applying a
+// Morphism to subIt's results: it seeds a frontier from subIt, then does a
+// breadth-first walk outward, applying the morphism to each newly
+// discovered node to find the next layer. A visited set (keyed by the
+// QuadStore's name for each node) makes cycles a no-op instead of an
+// infinite loop. maxDepth bounds how many layers out it walks; 0 means
+// unbounded. It backs Gremlin's .Repeat(morphism, depth).
+//
+// subIt's own results (depth 0) are never yielded -- Recursive only
+// reports nodes reached by applying the morphism at least once, so
+// g.V("x").Repeat(m) gives the closure of m over x, not x itself. Every
+// result is tagged "depth" with how many applications of the morphism it
+// took to reach it.
+type Recursive struct {
+	uid       uint64
+	tags      graph.Tagger
+	primaryIt graph.Iterator
+	morphism  Morphism
+	qs        graph.QuadStore
+	maxDepth  int
+
+	seeded  bool
+	visited map[string]bool
+	queue   []recursiveResult
+	front   []recursiveResult
+	depth   int
+	current recursiveResult
+}
+
+// NewRecursive creates a new Recursive iterator, seeding its frontier from
+// subIt and applying morphism up to maxDepth times (0 for unbounded).
+func NewRecursive(subIt graph.Iterator, morphism Morphism, qs graph.QuadStore, maxDepth int) *Recursive {
+	return &Recursive{
+		uid:       NextUID(),
+		primaryIt: subIt,
+		morphism:  morphism,
+		qs:        qs,
+		maxDepth:  maxDepth,
+	}
+}
+
+func (it *Recursive) UID() uint64 { return it.uid }
+
+func (it *Recursive) Reset() {
+	it.primaryIt.Reset()
+	it.seeded = false
+	it.visited = nil
+	it.queue = nil
+	it.front = nil
+	it.depth = 0
+	it.current = recursiveResult{}
+}
+
+func (it *Recursive) Tagger() *graph.Tagger { return &it.tags }
+
+func (it *Recursive) TagResults(dst map[string]graph.Value) {
+	it.tags.TagResult(dst, it.Result())
+	dst["depth"] = int64(it.current.Depth)
+}
+
+func (it *Recursive) Clone() graph.Iterator {
+	out := NewRecursive(it.primaryIt.Clone(), it.morphism, it.qs, it.maxDepth)
+	out.tags.CopyFrom(it)
+	return out
+}
+
+// seed primes the visited set and frontier from subIt's results, at depth
+// 0. Those results are the starting points for expand, not output: they
+// go into front so the first layer can be found, but not into queue, so
+// Recursive never yields a node back that it was just handed.
+func (it *Recursive) seed() {
+	it.visited = make(map[string]bool)
+	for it.primaryIt.Next() {
+		v := it.primaryIt.Result()
+		key := it.qs.NameOf(v)
+		if it.visited[key] {
+			continue
+		}
+		it.visited[key] = true
+		it.front = append(it.front, recursiveResult{Value: v, Depth: 0})
+	}
+	it.seeded = true
+}
+
+func (it *Recursive) addIfNew(v graph.Value, depth int) {
+	key := it.qs.NameOf(v)
+	if it.visited[key] {
+		return
+	}
+	it.visited[key] = true
+	r := recursiveResult{Value: v, Depth: depth}
+	it.queue = append(it.queue, r)
+	it.front = append(it.front, r)
+}
+
+// expand walks the current frontier one layer further out, refilling the
+// output queue and replacing the frontier with whatever was newly found.
+func (it *Recursive) expand() {
+	it.depth++
+	layer := it.front
+	it.front = nil
+	for _, node := range layer {
+		single := it.qs.FixedIterator()
+		single.Add(node.Value)
+		step := it.morphism(it.qs, single)
+		for step.Next() {
+			it.addIfNew(step.Result(), it.depth)
+		}
+		step.Close()
+	}
+}
+
+func (it *Recursive) Next() bool {
+	if !it.seeded {
+		it.seed()
+	}
+	for len(it.queue) == 0 {
+		if len(it.front) == 0 {
+			return false
+		}
+		if it.maxDepth != 0 && it.depth >= it.maxDepth {
+			return false
+		}
+		it.expand()
+	}
+	it.current, it.queue = it.queue[0], it.queue[1:]
+	return true
+}
+
+func (it *Recursive) Err() error { return it.primaryIt.Err() }
+
+func (it *Recursive) Result() graph.Value { return it.current.Value }
+
+func (it *Recursive) NextPath() bool { return false }
+
+func (it *Recursive) Contains(graph.Value) bool { return false }
+
+func (it *Recursive) Close() error { return it.primaryIt.Close() }
+
+func (it *Recursive) Size() (int64, bool) { return 0, false }
+
+func (it *Recursive) Type() graph.Type { return graph.Recursive }
+
+func (it *Recursive) Optimize() (graph.Iterator, bool) { return it, false }
+
+func (it *Recursive) SubIterators() []graph.Iterator { return []graph.Iterator{it.primaryIt} }
+
+func (it *Recursive) Stats() graph.IteratorStats {
+	stats := it.primaryIt.Stats()
+	return graph.IteratorStats{
+		ContainsCost: stats.ContainsCost,
+		NextCost:     stats.NextCost * 2,
+		Size:         stats.Size * 2,
+	}
+}
+
+func (it *Recursive) Describe() graph.Description {
+	return graph.Description{
+		UID:      it.UID(),
+		Type:     it.Type(),
+		Iterator: it.primaryIt.Describe(),
+	}
+}
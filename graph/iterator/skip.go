@@ -0,0 +1,101 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+import "github.com/google/cayley/graph"
+
+// Skip wraps another iterator and discards its first n results.
+type Skip struct {
+	uid       uint64
+	tags      graph.Tagger
+	primaryIt graph.Iterator
+	skip      int64
+	skipped   int64
+}
+
+// NewSkip creates a new Skip iterator, wrapping subIt and discarding its
+// first skip results.
+func NewSkip(subIt graph.Iterator, skip int64) *Skip {
+	return &Skip{
+		uid:       NextUID(),
+		primaryIt: subIt,
+		skip:      skip,
+	}
+}
+
+func (it *Skip) UID() uint64 { return it.uid }
+
+func (it *Skip) Reset() {
+	it.primaryIt.Reset()
+	it.skipped = 0
+}
+
+func (it *Skip) Tagger() *graph.Tagger { return &it.tags }
+
+func (it *Skip) TagResults(dst map[string]graph.Value) {
+	it.tags.TagResult(dst, it.Result())
+	it.primaryIt.TagResults(dst)
+}
+
+func (it *Skip) Clone() graph.Iterator {
+	out := NewSkip(it.primaryIt.Clone(), it.skip)
+	out.tags.CopyFrom(it)
+	return out
+}
+
+func (it *Skip) Next() bool {
+	for it.skipped < it.skip {
+		if !it.primaryIt.Next() {
+			return false
+		}
+		it.skipped++
+	}
+	return it.primaryIt.Next()
+}
+
+func (it *Skip) Err() error { return it.primaryIt.Err() }
+
+func (it *Skip) Result() graph.Value { return it.primaryIt.Result() }
+
+func (it *Skip) NextPath() bool { return it.primaryIt.NextPath() }
+
+func (it *Skip) Contains(v graph.Value) bool { return it.primaryIt.Contains(v) }
+
+func (it *Skip) Close() error { return it.primaryIt.Close() }
+
+func (it *Skip) Size() (int64, bool) {
+	size, exact := it.primaryIt.Size()
+	size -= it.skip
+	if size < 0 {
+		size = 0
+	}
+	return size, exact
+}
+
+func (it *Skip) Type() graph.Type { return graph.Skip }
+
+func (it *Skip) Optimize() (graph.Iterator, bool) { return it, false }
+
+func (it *Skip) SubIterators() []graph.Iterator { return []graph.Iterator{it.primaryIt} }
+
+func (it *Skip) Stats() graph.IteratorStats { return it.primaryIt.Stats() }
+
+func (it *Skip) Describe() graph.Description {
+	return graph.Description{
+		UID:      it.UID(),
+		Type:     it.Type(),
+		Iterator: it.primaryIt.Describe(),
+	}
+}
@@ -0,0 +1,171 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package iterator
+
+import (
+	"sort"
+
+	"github.com/google/cayley/graph"
+)
+
+// Sort fully consumes another iterator and yields its results ordered by
+// name -- either the name of the result itself, if tag is empty, or the
+// name of the value tagged tag. Like GroupCount, it materializes on the
+// first call to Next rather than pulling through.
+type Sort struct {
+	uid        uint64
+	tags       graph.Tagger
+	primaryIt  graph.Iterator
+	qs         graph.QuadStore
+	tag        string
+	results    []graph.Value
+	resultTags []map[string]graph.Value
+	index      int
+	ready      bool
+}
+
+// NewSort creates a new Sort, wrapping subIt and ordering by the value
+// tagged tag, or by the result itself if tag is "".
+func NewSort(subIt graph.Iterator, tag string, qs graph.QuadStore) *Sort {
+	return &Sort{
+		uid:       NextUID(),
+		primaryIt: subIt,
+		qs:        qs,
+		tag:       tag,
+		index:     -1,
+	}
+}
+
+func (it *Sort) UID() uint64 { return it.uid }
+
+func (it *Sort) Reset() {
+	it.primaryIt.Reset()
+	it.results = nil
+	it.resultTags = nil
+	it.index = -1
+	it.ready = false
+}
+
+func (it *Sort) Tagger() *graph.Tagger { return &it.tags }
+
+func (it *Sort) TagResults(dst map[string]graph.Value) {
+	it.tags.TagResult(dst, it.Result())
+	if it.index >= 0 && it.index < len(it.resultTags) {
+		for k, v := range it.resultTags[it.index] {
+			dst[k] = v
+		}
+	}
+}
+
+func (it *Sort) Clone() graph.Iterator {
+	out := NewSort(it.primaryIt.Clone(), it.tag, it.qs)
+	out.tags.CopyFrom(it)
+	return out
+}
+
+func (it *Sort) keyFor(result graph.Value, tagged map[string]graph.Value) string {
+	if it.tag == "" {
+		return it.qs.NameOf(result)
+	}
+	if v, ok := tagged[it.tag]; ok {
+		return it.qs.NameOf(v)
+	}
+	return it.qs.NameOf(result)
+}
+
+func (it *Sort) materialize() {
+	var results []graph.Value
+	var keys []string
+	var tagsList []map[string]graph.Value
+	collect := func() {
+		r := it.primaryIt.Result()
+		dst := make(map[string]graph.Value)
+		it.primaryIt.TagResults(dst)
+		results = append(results, r)
+		keys = append(keys, it.keyFor(r, dst))
+		tagsList = append(tagsList, dst)
+	}
+	for it.primaryIt.Next() {
+		collect()
+		for it.primaryIt.NextPath() {
+			collect()
+		}
+	}
+	idx := make([]int, len(results))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(i, j int) bool { return keys[idx[i]] < keys[idx[j]] })
+	sorted := make([]graph.Value, len(results))
+	sortedTags := make([]map[string]graph.Value, len(results))
+	for i, j := range idx {
+		sorted[i] = results[j]
+		sortedTags[i] = tagsList[j]
+	}
+	it.results = sorted
+	it.resultTags = sortedTags
+	it.ready = true
+}
+
+func (it *Sort) Next() bool {
+	if !it.ready {
+		it.materialize()
+	}
+	if it.index+1 >= len(it.results) {
+		return false
+	}
+	it.index++
+	return true
+}
+
+func (it *Sort) Err() error { return it.primaryIt.Err() }
+
+func (it *Sort) Result() graph.Value {
+	if it.index < 0 || it.index >= len(it.results) {
+		return nil
+	}
+	return it.results[it.index]
+}
+
+func (it *Sort) NextPath() bool { return false }
+
+func (it *Sort) Contains(v graph.Value) bool { return it.primaryIt.Contains(v) }
+
+func (it *Sort) Close() error { return it.primaryIt.Close() }
+
+func (it *Sort) Size() (int64, bool) { return int64(len(it.results)), it.ready }
+
+func (it *Sort) Type() graph.Type { return graph.Sort }
+
+func (it *Sort) Optimize() (graph.Iterator, bool) { return it, false }
+
+func (it *Sort) SubIterators() []graph.Iterator { return []graph.Iterator{it.primaryIt} }
+
+func (it *Sort) Stats() graph.IteratorStats {
+	stats := it.primaryIt.Stats()
+	return graph.IteratorStats{
+		ContainsCost: stats.ContainsCost,
+		NextCost:     stats.NextCost * 2,
+		Size:         stats.Size,
+	}
+}
+
+func (it *Sort) Describe() graph.Description {
+	return graph.Description{
+		UID:      it.UID(),
+		Type:     it.Type(),
+		Iterator: it.primaryIt.Describe(),
+	}
+}
@@ -0,0 +1,399 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package path provides an intermediate, language-agnostic representation
+// of a graph traversal. Front-ends such as Gremlin and MQL parse their own
+// syntax and lower it into a *Path; Path itself is the only thing that
+// knows how to turn a traversal into a graph.Iterator. This keeps the
+// iterator-construction strategy, the tag-propagation rules, and any future
+// optimizations in one place instead of duplicated across every front-end.
+package path
+
+import (
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/graph/iterator"
+	"github.com/google/cayley/quad"
+)
+
+// Morphism is a single step of a Path. Given the QuadStore the traversal
+// will run against and the iterator built by the previous step, it returns
+// the iterator for this step.
+type Morphism func(qs graph.QuadStore, base graph.Iterator) graph.Iterator
+
+// Path represents a path for query, made up of a stack of Morphisms to be
+// applied in order. A Path built with StartPath begins at a fixed set of
+// nodes (or every node, if none are given); one built with StartMorphism
+// begins at nothing and is meant to be spliced into another Path with
+// Follow.
+type Path struct {
+	stack []Morphism
+}
+
+// StartPath creates a new Path that starts at the given nodes. With no
+// nodes given, it starts at every node in the graph.
+func StartPath(nodes ...string) *Path {
+	return &Path{stack: []Morphism{vertexMorphism(nodes)}}
+}
+
+// StartMorphism creates a new, empty Path. It has no starting nodes of its
+// own and is meant to be used as a reusable fragment, applied to some other
+// Path via Follow.
+func StartMorphism() *Path {
+	return &Path{}
+}
+
+func (p *Path) clone() *Path {
+	stack := make([]Morphism, len(p.stack))
+	copy(stack, p.stack)
+	return &Path{stack: stack}
+}
+
+func (p *Path) append(m Morphism) *Path {
+	out := p.clone()
+	out.stack = append(out.stack, m)
+	return out
+}
+
+// BuildIteratorOn compiles the Path into a graph.Iterator that will run
+// against qs.
+func (p *Path) BuildIteratorOn(qs graph.QuadStore) graph.Iterator {
+	var it graph.Iterator = iterator.NewNull()
+	for _, m := range p.stack {
+		it = m(qs, it)
+	}
+	return it
+}
+
+// Null returns a Path that always compiles to an iterator yielding no
+// results. Front-ends use it to represent a malformed traversal without
+// needing to special-case an error return everywhere a Path is expected.
+func Null() *Path {
+	return StartMorphism().append(func(qs graph.QuadStore, base graph.Iterator) graph.Iterator {
+		return iterator.NewNull()
+	})
+}
+
+func vertexMorphism(nodes []string) Morphism {
+	return func(qs graph.QuadStore, base graph.Iterator) graph.Iterator {
+		if len(nodes) == 0 {
+			return qs.NodesAllIterator()
+		}
+		fixed := qs.FixedIterator()
+		for _, n := range nodes {
+			fixed.Add(qs.ValueOf(n))
+		}
+		return fixed
+	}
+}
+
+// predicateIterator is for the predicate side of a traversal, where no
+// predicates given conventionally means "any predicate".
+func predicateIterator(qs graph.QuadStore, via []string) graph.Iterator {
+	if len(via) == 0 {
+		return qs.NodesAllIterator()
+	}
+	return fixedIterator(qs, via)
+}
+
+// fixedIterator is for the object/node side of a traversal, where no nodes
+// given means "match nothing" -- the empty set, not "any node".
+func fixedIterator(qs graph.QuadStore, nodes []string) graph.Iterator {
+	fixed := qs.FixedIterator()
+	for _, n := range nodes {
+		fixed.Add(qs.ValueOf(n))
+	}
+	return fixed
+}
+
+func inOutIterator(qs graph.QuadStore, base graph.Iterator, via []string, isReverse bool) graph.Iterator {
+	inDir, outDir := quad.Subject, quad.Object
+	if isReverse {
+		inDir, outDir = outDir, inDir
+	}
+	and := iterator.NewAnd(qs)
+	and.AddSubIterator(iterator.NewLinksTo(qs, predicateIterator(qs, via), quad.Predicate))
+	and.AddSubIterator(iterator.NewLinksTo(qs, base, inDir))
+	return iterator.NewHasA(qs, and, outDir)
+}
+
+// Out updates the Path to walk from its current nodes to the nodes they
+// point to via the given predicates. With no predicates given, it walks via
+// every predicate.
+func (p *Path) Out(via ...string) *Path {
+	return p.append(func(qs graph.QuadStore, base graph.Iterator) graph.Iterator {
+		return inOutIterator(qs, base, via, false)
+	})
+}
+
+// In is the reverse of Out -- it walks from the current nodes to the nodes
+// that point to them via the given predicates.
+func (p *Path) In(via ...string) *Path {
+	return p.append(func(qs graph.QuadStore, base graph.Iterator) graph.Iterator {
+		return inOutIterator(qs, base, via, true)
+	})
+}
+
+// Both walks in either direction via the given predicates. It is the union
+// of In and Out.
+func (p *Path) Both(via ...string) *Path {
+	return p.append(func(qs graph.QuadStore, base graph.Iterator) graph.Iterator {
+		clone := base.Clone()
+		or := iterator.NewOr()
+		or.AddSubIterator(inOutIterator(qs, base, via, false))
+		or.AddSubIterator(inOutIterator(qs, clone, via, true))
+		return or
+	})
+}
+
+// InPredicates returns the set of predicates that point to the current
+// nodes.
+func (p *Path) InPredicates() *Path {
+	return p.append(func(qs graph.QuadStore, base graph.Iterator) graph.Iterator {
+		lto := iterator.NewLinksTo(qs, base, quad.Object)
+		return iterator.NewUnique(iterator.NewHasA(qs, lto, quad.Predicate))
+	})
+}
+
+// OutPredicates returns the set of predicates that originate from the
+// current nodes.
+func (p *Path) OutPredicates() *Path {
+	return p.append(func(qs graph.QuadStore, base graph.Iterator) graph.Iterator {
+		lto := iterator.NewLinksTo(qs, base, quad.Subject)
+		return iterator.NewUnique(iterator.NewHasA(qs, lto, quad.Predicate))
+	})
+}
+
+func hasMorphism(via []string, objects func(qs graph.QuadStore) graph.Iterator) Morphism {
+	return func(qs graph.QuadStore, base graph.Iterator) graph.Iterator {
+		subAnd := iterator.NewAnd(qs)
+		subAnd.AddSubIterator(iterator.NewLinksTo(qs, predicateIterator(qs, via), quad.Predicate))
+		subAnd.AddSubIterator(iterator.NewLinksTo(qs, objects(qs), quad.Object))
+		hasa := iterator.NewHasA(qs, subAnd, quad.Subject)
+		and := iterator.NewAnd(qs)
+		and.AddSubIterator(hasa)
+		and.AddSubIterator(base)
+		return and
+	}
+}
+
+// Has limits the Path to nodes that have an outbound edge, labeled by one
+// of via, to one of nodes. With no nodes given, the object side is the
+// empty set, so the Path matches nothing.
+func (p *Path) Has(via []string, nodes ...string) *Path {
+	return p.append(hasMorphism(via, func(qs graph.QuadStore) graph.Iterator {
+		return fixedIterator(qs, nodes)
+	}))
+}
+
+// HasPath is the sub-path form of Has: instead of a fixed set of literal
+// object values, it limits the Path to nodes that have an outbound edge,
+// labeled by one of via, into the set of nodes described by objects. This
+// lets a caller write the equivalent of
+// Has(predicate, Vertex("X").Out("type")) rather than only fixed values.
+func (p *Path) HasPath(via []string, objects *Path) *Path {
+	return p.append(hasMorphism(via, objects.BuildIteratorOn))
+}
+
+// HasFilter is the comparison-based sibling of Has: it limits the Path to
+// nodes that have an outbound edge, labeled via, whose object satisfies the
+// given comparison against value.
+func (p *Path) HasFilter(via []string, op iterator.Operator, value graph.Value) *Path {
+	return p.append(func(qs graph.QuadStore, base graph.Iterator) graph.Iterator {
+		allObjs := iterator.NewLinksTo(qs, qs.NodesAllIterator(), quad.Object)
+		subAnd := iterator.NewAnd(qs)
+		subAnd.AddSubIterator(iterator.NewLinksTo(qs, predicateIterator(qs, via), quad.Predicate))
+		subAnd.AddSubIterator(iterator.NewComparison(allObjs, op, value, qs))
+		hasa := iterator.NewHasA(qs, subAnd, quad.Subject)
+		and := iterator.NewAnd(qs)
+		and.AddSubIterator(hasa)
+		and.AddSubIterator(base)
+		return and
+	})
+}
+
+// Tag adds tags to the nodes at this point of the Path, without otherwise
+// changing the path.
+func (p *Path) Tag(tags ...string) *Path {
+	return p.append(func(qs graph.QuadStore, base graph.Iterator) graph.Iterator {
+		for _, t := range tags {
+			base.Tagger().Add(t)
+		}
+		return base
+	})
+}
+
+// Save is a shorthand for walking Out via a predicate, tagging the result,
+// and combining it back with the current path, without advancing the
+// current nodes.
+func (p *Path) Save(via, tag string) *Path {
+	return p.append(func(qs graph.QuadStore, base graph.Iterator) graph.Iterator {
+		all := qs.NodesAllIterator()
+		all.Tagger().Add(tag)
+		predFixed := qs.FixedIterator()
+		predFixed.Add(qs.ValueOf(via))
+		subAnd := iterator.NewAnd(qs)
+		subAnd.AddSubIterator(iterator.NewLinksTo(qs, predFixed, quad.Predicate))
+		subAnd.AddSubIterator(iterator.NewLinksTo(qs, all, quad.Object))
+		hasa := iterator.NewHasA(qs, subAnd, quad.Subject)
+		and := iterator.NewAnd(qs)
+		and.AddSubIterator(hasa)
+		and.AddSubIterator(base)
+		return and
+	})
+}
+
+// SaveReverse is the reverse of Save -- it saves the subject of the
+// predicate, rather than the object, as tag.
+func (p *Path) SaveReverse(via, tag string) *Path {
+	return p.append(func(qs graph.QuadStore, base graph.Iterator) graph.Iterator {
+		all := qs.NodesAllIterator()
+		all.Tagger().Add(tag)
+		predFixed := qs.FixedIterator()
+		predFixed.Add(qs.ValueOf(via))
+		subAnd := iterator.NewAnd(qs)
+		subAnd.AddSubIterator(iterator.NewLinksTo(qs, predFixed, quad.Predicate))
+		subAnd.AddSubIterator(iterator.NewLinksTo(qs, all, quad.Subject))
+		hasa := iterator.NewHasA(qs, subAnd, quad.Object)
+		and := iterator.NewAnd(qs)
+		and.AddSubIterator(hasa)
+		and.AddSubIterator(base)
+		return and
+	})
+}
+
+// And intersects this Path with another, independently-rooted Path.
+func (p *Path) And(other *Path) *Path {
+	return p.append(func(qs graph.QuadStore, base graph.Iterator) graph.Iterator {
+		and := iterator.NewAnd(qs)
+		and.AddSubIterator(base)
+		and.AddSubIterator(other.BuildIteratorOn(qs))
+		return and
+	})
+}
+
+// Or unions this Path with another, independently-rooted Path.
+func (p *Path) Or(other *Path) *Path {
+	return p.append(func(qs graph.QuadStore, base graph.Iterator) graph.Iterator {
+		or := iterator.NewOr()
+		or.AddSubIterator(base)
+		or.AddSubIterator(other.BuildIteratorOn(qs))
+		return or
+	})
+}
+
+// Except removes the nodes in other from this Path.
+func (p *Path) Except(other *Path) *Path {
+	return p.append(func(qs graph.QuadStore, base graph.Iterator) graph.Iterator {
+		notIt := iterator.NewNot(other.BuildIteratorOn(qs), qs.NodesAllIterator())
+		and := iterator.NewAnd(qs)
+		and.AddSubIterator(base)
+		and.AddSubIterator(notIt)
+		return and
+	})
+}
+
+// Back re-intersects the Path with a previously-built sub-path, the
+// Path equivalent of Gremlin's .Back().
+func (p *Path) Back(via *Path) *Path {
+	return p.append(func(qs graph.QuadStore, base graph.Iterator) graph.Iterator {
+		and := iterator.NewAnd(qs)
+		and.AddSubIterator(base)
+		and.AddSubIterator(via.BuildIteratorOn(qs))
+		return and
+	})
+}
+
+// Is limits the Path to the given set of nodes, intersected with whatever
+// the Path already contains.
+func (p *Path) Is(nodes ...string) *Path {
+	return p.append(func(qs graph.QuadStore, base graph.Iterator) graph.Iterator {
+		and := iterator.NewAnd(qs)
+		and.AddSubIterator(fixedIterator(qs, nodes))
+		and.AddSubIterator(base)
+		return and
+	})
+}
+
+// Follow applies a reusable morphism -- a Path built with StartMorphism --
+// on top of the current Path, continuing from the current nodes rather
+// than starting over.
+func (p *Path) Follow(morphism *Path) *Path {
+	return p.append(func(qs graph.QuadStore, base graph.Iterator) graph.Iterator {
+		it := base
+		for _, m := range morphism.stack {
+			it = m(qs, it)
+		}
+		return it
+	})
+}
+
+// Repeat computes the transitive closure of applying morphism to the
+// current nodes: their direct neighbors via morphism, then those nodes'
+// neighbors, and so on, up to maxDepth layers out (0 for unbounded). The
+// current nodes themselves are not part of the result, only what's
+// reached by applying morphism at least once; each result is tagged
+// "depth" with how many applications it took to reach it. It backs
+// Gremlin's .Repeat(morphism, depth), the traversal used for
+// ancestor/descendant-style queries.
+func (p *Path) Repeat(morphism *Path, maxDepth int) *Path {
+	return p.append(func(qs graph.QuadStore, base graph.Iterator) graph.Iterator {
+		step := func(qs graph.QuadStore, layer graph.Iterator) graph.Iterator {
+			it := layer
+			for _, m := range morphism.stack {
+				it = m(qs, it)
+			}
+			return it
+		}
+		return iterator.NewRecursive(base, step, qs, maxDepth)
+	})
+}
+
+// Count replaces the current nodes with a single result: how many of them
+// there are.
+func (p *Path) Count() *Path {
+	return p.append(func(qs graph.QuadStore, base graph.Iterator) graph.Iterator {
+		return iterator.NewCount(base)
+	})
+}
+
+// GroupCount replaces the current nodes with one result per distinct value
+// tagged tag, each carrying how many times that value occurred.
+func (p *Path) GroupCount(tag string) *Path {
+	return p.append(func(qs graph.QuadStore, base graph.Iterator) graph.Iterator {
+		return iterator.NewGroupCount(base, tag, qs)
+	})
+}
+
+// Limit caps the Path to at most n results. A non-positive n leaves the
+// Path unbounded.
+func (p *Path) Limit(n int64) *Path {
+	return p.append(func(qs graph.QuadStore, base graph.Iterator) graph.Iterator {
+		return iterator.NewLimit(base, n)
+	})
+}
+
+// Skip discards the first n results of the Path.
+func (p *Path) Skip(n int64) *Path {
+	return p.append(func(qs graph.QuadStore, base graph.Iterator) graph.Iterator {
+		return iterator.NewSkip(base, n)
+	})
+}
+
+// OrderBy sorts the Path's results by name -- either their own, or, if tag
+// is non-empty, that of the value tagged tag.
+func (p *Path) OrderBy(tag string) *Path {
+	return p.append(func(qs graph.QuadStore, base graph.Iterator) graph.Iterator {
+		return iterator.NewSort(base, tag, qs)
+	})
+}
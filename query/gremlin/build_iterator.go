@@ -15,14 +15,16 @@
 package gremlin
 
 import (
+	"math"
 	"strconv"
+	"time"
 
 	"github.com/barakmich/glog"
 	"github.com/robertkrimen/otto"
 
 	"github.com/google/cayley/graph"
 	"github.com/google/cayley/graph/iterator"
-	"github.com/google/cayley/quad"
+	"github.com/google/cayley/graph/path"
 )
 
 func propertiesOf(obj *otto.Object, name string) []string {
@@ -101,11 +103,66 @@ func convertOttoObjToIntArray(val otto.Value) ([]int64, bool) {
 	return strarr, true
 }
 
+// convertOttoValueToTyped converts a single scalar JS value into the
+// closest Go type, rather than coercing everything through String() the
+// way Has() comparisons used to. A whole-number JS Number stays int64,
+// matching what iterator.Comparison already knows how to compare and
+// preserving existing Has("age", gt, 30)-style queries; a fractional one
+// becomes a float64 rather than getting truncated, so Has("price", gt,
+// 9.99) compares against 9.99 and not 9. A JS Date becomes a time.Time
+// and a JS Boolean a bool, so Has() comparisons can be extended to those
+// types without another pass over this function.
+//
+// JS has no notion of a language-tagged string literal -- that's an RDF
+// concept, not a JS one -- and quad's typed/language-tagged literal
+// values aren't reachable from this package, so a JS String can only
+// become a bare Go string here; a caller that needs to compare against a
+// language-tagged quad value has to build that comparison value itself.
+func convertOttoValueToTyped(val otto.Value) (graph.Value, bool) {
+	switch {
+	case val.IsBoolean():
+		b, err := val.ToBoolean()
+		if err != nil {
+			return nil, false
+		}
+		return b, true
+	case val.Class() == "Date":
+		thing, err := val.Export()
+		if err != nil {
+			return nil, false
+		}
+		t, ok := thing.(time.Time)
+		return t, ok
+	case val.IsNumber():
+		f, err := val.ToFloat()
+		if err != nil {
+			return nil, false
+		}
+		if f == math.Trunc(f) && !math.IsInf(f, 0) {
+			return int64(f), true
+		}
+		return f, true
+	case val.IsString():
+		s, err := val.ToString()
+		if err != nil {
+			return nil, false
+		}
+		return s, true
+	default:
+		return nil, false
+	}
+}
+
+// buildIteratorTree lowers a Gremlin chain into a graph.Iterator. It is a
+// thin wrapper around the shared graph/path compiler: all of the iterator
+// construction, tag propagation, and And/Or/Not wiring lives in
+// graph/path, so that Gremlin and any other front-end compiling to a Path
+// share exactly one strategy for it.
 func buildIteratorTree(obj *otto.Object, qs graph.QuadStore) graph.Iterator {
 	if !isVertexChain(obj) {
 		return iterator.NewNull()
 	}
-	return buildIteratorTreeHelper(obj, qs, iterator.NewNull())
+	return compileGremlinPath(obj).BuildIteratorOn(qs)
 }
 
 func stringsFrom(obj *otto.Object) []string {
@@ -166,290 +223,211 @@ func buildIteratorFromValue(val otto.Value, qs graph.QuadStore) graph.Iterator {
 	}
 }
 
-func buildInOutIterator(obj *otto.Object, qs graph.QuadStore, base graph.Iterator, isReverse bool) graph.Iterator {
-	argList, _ := obj.Get("_gremlin_values")
-	if argList.Class() != "GoArray" {
-		glog.Errorln("How is arglist not an array? Return nothing.", argList.Class())
-		return iterator.NewNull()
-	}
-	argArray := argList.Object()
-	lengthVal, _ := argArray.Get("length")
-	length, _ := lengthVal.ToInteger()
-	var predicateNodeIterator graph.Iterator
-	if length == 0 {
-		predicateNodeIterator = qs.NodesAllIterator()
-	} else {
-		zero, _ := argArray.Get("0")
-		predicateNodeIterator = buildIteratorFromValue(zero, qs)
-	}
-	if length >= 2 {
-		var tags []string
-		one, _ := argArray.Get("1")
-		if one.IsString() {
-			tags = append(tags, one.String())
-		} else if one.Class() == "Array" {
-			tags = stringsFrom(one.Object())
-		}
-		for _, tag := range tags {
-			predicateNodeIterator.Tagger().Add(tag)
-		}
-	}
-
-	in, out := quad.Subject, quad.Object
-	if isReverse {
-		in, out = out, in
-	}
-	lto := iterator.NewLinksTo(qs, base, in)
-	and := iterator.NewAnd(qs)
-	and.AddSubIterator(iterator.NewLinksTo(qs, predicateNodeIterator, quad.Predicate))
-	and.AddSubIterator(lto)
-	return iterator.NewHasA(qs, and, out)
-}
-
-func buildInOutPredicateIterator(obj *otto.Object, qs graph.QuadStore, base graph.Iterator, isReverse bool) graph.Iterator {
-	dir := quad.Subject
-	if isReverse {
-		dir = quad.Object
-	}
-	lto := iterator.NewLinksTo(qs, base, dir)
-	hasa := iterator.NewHasA(qs, lto, quad.Predicate)
-	return iterator.NewUnique(hasa)
-}
-
-func buildIteratorTreeHelper(obj *otto.Object, qs graph.QuadStore, base graph.Iterator) graph.Iterator {
-	// TODO: Better error handling
-	var (
-		it    graph.Iterator
-		subIt graph.Iterator
-	)
-
-	if prev, _ := obj.Get("_gremlin_prev"); !prev.IsObject() {
-		subIt = base
+// compileGremlinPath walks the _gremlin_prev chain rooted at obj and lowers
+// it into a *path.Path, the same intermediate form any other query
+// front-end would compile to. It replaces the old buildIteratorTreeHelper,
+// which built the graph.Iterator tree by hand at every step of the switch.
+func compileGremlinPath(obj *otto.Object) *path.Path {
+	var p *path.Path
+	if prev, _ := obj.Get("_gremlin_prev"); prev.IsObject() {
+		p = compileGremlinPath(prev.Object())
 	} else {
-		subIt = buildIteratorTreeHelper(prev.Object(), qs, base)
+		p = path.StartMorphism()
 	}
 
 	stringArgs := propertiesOf(obj, "string_args")
 	val, _ := obj.Get("_gremlin_type")
 	switch val.String() {
 	case "vertex":
-		if len(stringArgs) == 0 {
-			it = qs.NodesAllIterator()
-		} else {
-			fixed := qs.FixedIterator()
-			for _, name := range stringArgs {
-				fixed.Add(qs.ValueOf(name))
-			}
-			it = fixed
-		}
+		p = path.StartPath(stringArgs...)
+	case "morphism":
+		// The root of a reusable morphism chain: nothing to apply yet.
 	case "tag":
-		it = subIt
-		for _, tag := range stringArgs {
-			it.Tagger().Add(tag)
-		}
+		p = p.Tag(stringArgs...)
 	case "save":
-		all := qs.NodesAllIterator()
-		if len(stringArgs) > 2 || len(stringArgs) == 0 {
-			return iterator.NewNull()
+		if len(stringArgs) == 0 || len(stringArgs) > 2 {
+			return path.Null()
 		}
+		tag := stringArgs[0]
 		if len(stringArgs) == 2 {
-			all.Tagger().Add(stringArgs[1])
-		} else {
-			all.Tagger().Add(stringArgs[0])
+			tag = stringArgs[1]
 		}
-		predFixed := qs.FixedIterator()
-		predFixed.Add(qs.ValueOf(stringArgs[0]))
-		subAnd := iterator.NewAnd(qs)
-		subAnd.AddSubIterator(iterator.NewLinksTo(qs, predFixed, quad.Predicate))
-		subAnd.AddSubIterator(iterator.NewLinksTo(qs, all, quad.Object))
-		hasa := iterator.NewHasA(qs, subAnd, quad.Subject)
-		and := iterator.NewAnd(qs)
-		and.AddSubIterator(hasa)
-		and.AddSubIterator(subIt)
-		it = and
+		p = p.Save(stringArgs[0], tag)
 	case "saver":
-		all := qs.NodesAllIterator()
-		if len(stringArgs) > 2 || len(stringArgs) == 0 {
-			return iterator.NewNull()
+		if len(stringArgs) == 0 || len(stringArgs) > 2 {
+			return path.Null()
 		}
+		tag := stringArgs[0]
 		if len(stringArgs) == 2 {
-			all.Tagger().Add(stringArgs[1])
-		} else {
-			all.Tagger().Add(stringArgs[0])
+			tag = stringArgs[1]
 		}
-		predFixed := qs.FixedIterator()
-		predFixed.Add(qs.ValueOf(stringArgs[0]))
-		subAnd := iterator.NewAnd(qs)
-		subAnd.AddSubIterator(iterator.NewLinksTo(qs, predFixed, quad.Predicate))
-		subAnd.AddSubIterator(iterator.NewLinksTo(qs, all, quad.Subject))
-		hasa := iterator.NewHasA(qs, subAnd, quad.Object)
-		and := iterator.NewAnd(qs)
-		and.AddSubIterator(hasa)
-		and.AddSubIterator(subIt)
-		it = and
+		p = p.SaveReverse(stringArgs[0], tag)
 	case "has":
-		args := propertyAsArray(obj, "_gremlin_values")
-		argCount := len(args)
-
-		if argCount < 2 || argCount > 3 {
-			return iterator.NewNull() //TODO throw JS invalid args error
-		}
-
-		if argCount == 3 { // Has(<predicate>, <operator>, <comparison_value>)
-			// iterator.Operator must be an integer
-			if !args[1].IsNumber() {
-				return iterator.NewNull() //TODO here be useuful error message
-			}
-			if !args[2].IsNumber() && !args[2].IsString() && args[2].Class() != "Array" {
-				return iterator.NewNull()
-			}
-			predFixed := qs.FixedIterator()
-			for _, name := range convertOttoObjToStrArray(args[0]) {
-				predFixed.Add(qs.ValueOf(name))
-			}
-			var value graph.Value = args[2].String()
-			var operator iterator.Operator
-
-			val, _ := args[1].ToInteger()
-			operator = iterator.Operator(val)
-
-			if args[2].Class() == "Array" {
-				if v, ok := convertOttoObjToIntArray(args[2]); ok {
-					value = v
-				} else {
-					value = convertOttoObjToStrArray(args[2])
-				}
-			} else {
-				if args[2].IsNumber() {
-					val, _ := args[2].ToInteger()
-					value = val
-				}
-				if args[2].IsString() {
-					val, _ := args[2].ToString()
-					value = val
-				}
-			}
-			subAnd := iterator.NewAnd(qs)
-			subAnd.AddSubIterator(iterator.NewLinksTo(qs, predFixed, quad.Predicate))
-			allObjs := iterator.NewLinksTo(qs, qs.NodesAllIterator(), quad.Object)
-			subAnd.AddSubIterator(iterator.NewComparison(allObjs, operator, value, qs))
-			hasa := iterator.NewHasA(qs, subAnd, quad.Subject)
-			and := iterator.NewAnd(qs)
-			and.AddSubIterator(hasa)
-			and.AddSubIterator(subIt)
-			it = and
-		} else { // Has(<predicate>, <object>)
-			predFixed := qs.FixedIterator()
-			for _, name := range convertOttoObjToStrArray(args[0]) {
-				predFixed.Add(qs.ValueOf(name))
-			}
-			objfixed := qs.FixedIterator()
-			for _, name := range convertOttoObjToStrArray(args[1]) {
-				objfixed.Add(qs.ValueOf(name))
-			}
-			subAnd := iterator.NewAnd(qs)
-			subAnd.AddSubIterator(iterator.NewLinksTo(qs, predFixed, quad.Predicate))
-			subAnd.AddSubIterator(iterator.NewLinksTo(qs, objfixed, quad.Object))
-			hasa := iterator.NewHasA(qs, subAnd, quad.Subject)
-			and := iterator.NewAnd(qs)
-			and.AddSubIterator(hasa)
-			and.AddSubIterator(subIt)
-			it = and
-		}
-	case "morphism":
-		it = base
+		p = compileHas(p, obj)
 	case "and":
 		arg, _ := obj.Get("_gremlin_values")
 		firstArg, _ := arg.Object().Get("0")
 		if !isVertexChain(firstArg.Object()) {
-			return iterator.NewNull()
+			return path.Null()
 		}
-		argIt := buildIteratorTree(firstArg.Object(), qs)
-
-		and := iterator.NewAnd(qs)
-		and.AddSubIterator(subIt)
-		and.AddSubIterator(argIt)
-		it = and
+		p = p.And(compileGremlinPath(firstArg.Object()))
 	case "back":
 		arg, _ := obj.Get("_gremlin_back_chain")
-		argIt := buildIteratorTree(arg.Object(), qs)
-		and := iterator.NewAnd(qs)
-		and.AddSubIterator(subIt)
-		and.AddSubIterator(argIt)
-		it = and
+		p = p.Back(compileGremlinPath(arg.Object()))
 	case "is":
-		fixed := qs.FixedIterator()
-		for _, name := range stringArgs {
-			fixed.Add(qs.ValueOf(name))
-		}
-		and := iterator.NewAnd(qs)
-		and.AddSubIterator(fixed)
-		and.AddSubIterator(subIt)
-		it = and
+		p = p.Is(stringArgs...)
 	case "or":
 		arg, _ := obj.Get("_gremlin_values")
 		firstArg, _ := arg.Object().Get("0")
 		if !isVertexChain(firstArg.Object()) {
-			return iterator.NewNull()
+			return path.Null()
 		}
-		argIt := buildIteratorTree(firstArg.Object(), qs)
-
-		or := iterator.NewOr()
-		or.AddSubIterator(subIt)
-		or.AddSubIterator(argIt)
-		it = or
+		p = p.Or(compileGremlinPath(firstArg.Object()))
 	case "both":
-		// Hardly the most efficient pattern, but the most general.
-		// Worth looking into an Optimize() optimization here.
-		clone := subIt.Clone()
-		it1 := buildInOutIterator(obj, qs, subIt, false)
-		it2 := buildInOutIterator(obj, qs, clone, true)
-
-		or := iterator.NewOr()
-		or.AddSubIterator(it1)
-		or.AddSubIterator(it2)
-		it = or
+		p = p.Both(inOutVia(obj)...)
 	case "out":
-		it = buildInOutIterator(obj, qs, subIt, false)
+		p = p.Out(inOutVia(obj)...)
+	case "in":
+		p = p.In(inOutVia(obj)...)
 	case "follow":
-		// Follow a morphism
 		arg, _ := obj.Get("_gremlin_values")
 		firstArg, _ := arg.Object().Get("0")
 		if isVertexChain(firstArg.Object()) {
-			return iterator.NewNull()
+			return path.Null()
 		}
-		it = buildIteratorTreeHelper(firstArg.Object(), qs, subIt)
+		p = p.Follow(compileGremlinPath(firstArg.Object()))
 	case "followr":
-		// Follow a morphism
 		arg, _ := obj.Get("_gremlin_followr")
 		if isVertexChain(arg.Object()) {
-			return iterator.NewNull()
+			return path.Null()
 		}
-		it = buildIteratorTreeHelper(arg.Object(), qs, subIt)
-	case "in":
-		it = buildInOutIterator(obj, qs, subIt, true)
+		p = p.Follow(compileGremlinPath(arg.Object()))
 	case "except":
 		arg, _ := obj.Get("_gremlin_values")
 		firstArg, _ := arg.Object().Get("0")
 		if !isVertexChain(firstArg.Object()) {
-			return iterator.NewNull()
+			return path.Null()
 		}
-
-		allIt := qs.NodesAllIterator()
-		toComplementIt := buildIteratorTree(firstArg.Object(), qs)
-		notIt := iterator.NewNot(toComplementIt, allIt)
-
-		and := iterator.NewAnd(qs)
-		and.AddSubIterator(subIt)
-		and.AddSubIterator(notIt)
-		it = and
+		p = p.Except(compileGremlinPath(firstArg.Object()))
 	case "in_predicates":
-		it = buildInOutPredicateIterator(obj, qs, subIt, true)
+		p = p.InPredicates()
 	case "out_predicates":
-		it = buildInOutPredicateIterator(obj, qs, subIt, false)
+		p = p.OutPredicates()
+	case "count":
+		p = p.Count()
+	case "group_count":
+		p = p.GroupCount(firstStringArg(obj))
+	case "limit":
+		p = p.Limit(firstIntArg(obj))
+	case "skip":
+		p = p.Skip(firstIntArg(obj))
+	case "order":
+		p = p.OrderBy(firstStringArg(obj))
+	case "repeat":
+		p = compileRepeat(p, obj)
+	}
+	return p
+}
+
+// firstIntArg returns the first element of _gremlin_values as an int64, or
+// 0 if there isn't one -- used by .Limit()/.Skip().
+func firstIntArg(obj *otto.Object) int64 {
+	args := propertyAsArray(obj, "_gremlin_values")
+	if len(args) == 0 {
+		return 0
+	}
+	n, _ := args[0].ToInteger()
+	return n
+}
+
+// firstStringArg returns the first element of _gremlin_values as a string,
+// or "" if there isn't one -- used by .GroupCount()/.OrderBy().
+func firstStringArg(obj *otto.Object) string {
+	args := propertyAsArray(obj, "_gremlin_values")
+	if len(args) == 0 {
+		return ""
+	}
+	return args[0].String()
+}
+
+// inOutVia pulls the predicate names out of the first .Out/.In/.Both
+// argument, which may be a single string or an array of them.
+func inOutVia(obj *otto.Object) []string {
+	argList, _ := obj.Get("_gremlin_values")
+	if argList.Class() != "GoArray" {
+		glog.Errorln("How is arglist not an array? Return nothing.", argList.Class())
+		return nil
+	}
+	argArray := argList.Object()
+	lengthVal, _ := argArray.Get("length")
+	length, _ := lengthVal.ToInteger()
+	if length == 0 {
+		return nil
+	}
+	zero, _ := argArray.Get("0")
+	return convertOttoObjToStrArray(zero)
+}
+
+// compileRepeat handles .Repeat(morphism, depth): morphism is the
+// sub-chain to apply transitively, depth an optional max depth (0, the
+// default when omitted, means unbounded).
+func compileRepeat(p *path.Path, obj *otto.Object) *path.Path {
+	arg, _ := obj.Get("_gremlin_values")
+	argArray := arg.Object()
+	firstArg, _ := argArray.Get("0")
+	if isVertexChain(firstArg.Object()) {
+		return path.Null()
+	}
+	morphism := compileGremlinPath(firstArg.Object())
+
+	depth := 0
+	lengthVal, _ := argArray.Get("length")
+	length, _ := lengthVal.ToInteger()
+	if length >= 2 {
+		one, _ := argArray.Get("1")
+		n, _ := one.ToInteger()
+		depth = int(n)
+	}
+	return p.Repeat(morphism, depth)
+}
+
+func compileHas(p *path.Path, obj *otto.Object) *path.Path {
+	args := propertyAsArray(obj, "_gremlin_values")
+	argCount := len(args)
+
+	if argCount < 2 || argCount > 3 {
+		return path.Null() //TODO throw JS invalid args error
+	}
+
+	via := convertOttoObjToStrArray(args[0])
+
+	if argCount == 3 { // Has(<predicate>, <operator>, <comparison_value>)
+		if !args[1].IsNumber() {
+			return path.Null() //TODO here be useuful error message
+		}
+		opVal, _ := args[1].ToInteger()
+		operator := iterator.Operator(opVal)
+
+		var value graph.Value
+		if args[2].Class() == "Array" {
+			if v, ok := convertOttoObjToIntArray(args[2]); ok {
+				value = v
+			} else {
+				value = convertOttoObjToStrArray(args[2])
+			}
+		} else {
+			v, ok := convertOttoValueToTyped(args[2])
+			if !ok {
+				return path.Null() //TODO here be a useful error message
+			}
+			value = v
+		}
+		return p.HasFilter(via, operator, value)
 	}
-	if it == nil {
-		panic("Iterator building does not catch the output iterator in some case.")
+	// Has(<predicate>, <object>) -- the object may itself be a vertex chain,
+	// e.g. Has(predicate, g.V("X").Out("type")), rather than a literal.
+	if args[1].Class() == "Object" && isVertexChain(args[1].Object()) {
+		return p.HasPath(via, compileGremlinPath(args[1].Object()))
 	}
-	return it
+	return p.Has(via, convertOttoObjToStrArray(args[1])...)
 }
@@ -0,0 +1,268 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gremlin
+
+import (
+	"errors"
+
+	"github.com/robertkrimen/otto"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/query/gremlin/plan"
+)
+
+var errNotAVertexChain = errors.New("gremlin: script did not resolve to a vertex path")
+
+// Session holds the otto VM a Gremlin script runs against, so a caller
+// doesn't have to thread one through on every call.
+type Session struct {
+	vm *otto.Otto
+}
+
+// NewSession creates a Session that compiles and runs scripts against vm.
+// vm must already have its Gremlin globals loaded (g.V, g.M, and the
+// Vertex/Morphism constructors); NewSession installs the aggregation and
+// Repeat extensions -- Count, GroupCount, Limit, Skip, OrderBy, Repeat --
+// onto both prototypes, so a script can chain them the same way it
+// chains .Out()/.In()/.Has().
+func NewSession(vm *otto.Otto) *Session {
+	for _, ctorName := range []string{"Vertex", "Morphism"} {
+		proto := prototypeOf(vm, ctorName)
+		if proto == nil {
+			continue
+		}
+		AddPathExtensions(proto)
+		AddRepeatExtension(proto)
+	}
+	return &Session{vm: vm}
+}
+
+// prototypeOf returns the prototype object of the named constructor
+// function in vm, or nil if vm has no such constructor.
+func prototypeOf(vm *otto.Otto, ctorName string) *otto.Object {
+	ctor, err := vm.Get(ctorName)
+	if err != nil || !ctor.IsFunction() {
+		return nil
+	}
+	protoVal, err := ctor.Object().Get("prototype")
+	if err != nil || !protoVal.IsObject() {
+		return nil
+	}
+	return protoVal.Object()
+}
+
+// Execute runs script against the Session's vm, builds the resulting
+// Gremlin chain into a graph.Iterator over qs, and returns its results
+// formatted the way a client expects: through formatResult, so Count's
+// int64 and GroupCount's GroupCountResult rows come back directly
+// instead of (failing) through qs.NameOf.
+func (s *Session) Execute(qs graph.QuadStore, script string) ([]interface{}, error) {
+	val, err := s.vm.Run(script)
+	if err != nil {
+		return nil, err
+	}
+	if !val.IsObject() {
+		return nil, errNotAVertexChain
+	}
+	it := buildIteratorTree(val.Object(), qs)
+	defer it.Close()
+
+	var results []interface{}
+	for it.Next() {
+		results = append(results, formatResult(qs, it.Result()))
+		for it.NextPath() {
+			results = append(results, formatResult(qs, it.Result()))
+		}
+	}
+	return results, it.Err()
+}
+
+// Compile runs script against the Session's vm and lowers the resulting
+// Gremlin chain into a plan.Plan, without building any graph.Iterator.
+// This is the entry point an HTTP handler uses to get a plan it can
+// cache by script hash, or hand off to a remote QuadStore worker, instead
+// of re-running the otto VM on every request for what is often the same
+// handful of queries.
+func (s *Session) Compile(script string) (plan.Plan, error) {
+	return Compile(s.vm, script)
+}
+
+// Compile runs script against vm and lowers the resulting Gremlin chain
+// into a plan.Plan. It's the package-level form of Session.Compile, kept
+// so callers that already have a bare *otto.Otto -- building one up for a
+// single one-off script, say -- don't need to wrap it in a Session first.
+func Compile(vm *otto.Otto, script string) (plan.Plan, error) {
+	val, err := vm.Run(script)
+	if err != nil {
+		return nil, err
+	}
+	if !val.IsObject() {
+		return nil, errNotAVertexChain
+	}
+	return CompilePlan(val.Object())
+}
+
+// CompilePlan lowers an already-built Gremlin chain into a plan.Plan.
+func CompilePlan(obj *otto.Object) (plan.Plan, error) {
+	if !isVertexChain(obj) {
+		return nil, errNotAVertexChain
+	}
+	return compileGremlinPlan(obj), nil
+}
+
+// compileGremlinPlan walks the _gremlin_prev chain the way
+// compileGremlinPath does, but emits plan.Steps instead of calling
+// graph/path methods directly, so the result can be serialized, cached,
+// and replayed without the otto VM that produced it. It does not repeat
+// compileGremlinPath's argument checks: the sub-chains of and/or/except
+// are not checked to be vertex chains, those of follow/followr/repeat are
+// not checked to be morphisms, and a three-argument has is not checked
+// for a numeric operator or a convertible value.
+func compileGremlinPlan(obj *otto.Object) plan.Plan {
+	var steps plan.Plan
+	if prev, _ := obj.Get("_gremlin_prev"); prev.IsObject() {
+		steps = compileGremlinPlan(prev.Object())
+	}
+
+	stringArgs := propertiesOf(obj, "string_args")
+	val, _ := obj.Get("_gremlin_type")
+	switch val.String() {
+	case "vertex":
+		steps = plan.Plan{{Op: "vertex", Args: toArgs(stringArgs)}}
+	case "morphism":
+		// Root of a reusable morphism chain: nothing to emit yet.
+	case "tag":
+		steps = append(steps, plan.Step{Op: "tag", Args: toArgs(stringArgs)})
+	case "save":
+		steps = append(steps, saveStep("save", stringArgs))
+	case "saver":
+		steps = append(steps, saveStep("saver", stringArgs))
+	case "is":
+		steps = append(steps, plan.Step{Op: "is", Args: toArgs(stringArgs)})
+	case "out":
+		steps = append(steps, plan.Step{Op: "out", Args: toArgs(inOutVia(obj))})
+	case "in":
+		steps = append(steps, plan.Step{Op: "in", Args: toArgs(inOutVia(obj))})
+	case "both":
+		steps = append(steps, plan.Step{Op: "both", Args: toArgs(inOutVia(obj))})
+	case "in_predicates":
+		steps = append(steps, plan.Step{Op: "in_predicates"})
+	case "out_predicates":
+		steps = append(steps, plan.Step{Op: "out_predicates"})
+	case "count":
+		steps = append(steps, plan.Step{Op: "count"})
+	case "limit":
+		steps = append(steps, plan.Step{Op: "limit", Args: []interface{}{float64(firstIntArg(obj))}})
+	case "skip":
+		steps = append(steps, plan.Step{Op: "skip", Args: []interface{}{float64(firstIntArg(obj))}})
+	case "group_count":
+		steps = append(steps, plan.Step{Op: "group_count", Args: []interface{}{firstStringArg(obj)}})
+	case "order":
+		steps = append(steps, plan.Step{Op: "order", Args: []interface{}{firstStringArg(obj)}})
+	case "has":
+		steps = append(steps, compileHasPlan(obj))
+	case "and":
+		steps = append(steps, plan.Step{Op: "and", Sub: compileGremlinPlan(firstChainArg(obj))})
+	case "or":
+		steps = append(steps, plan.Step{Op: "or", Sub: compileGremlinPlan(firstChainArg(obj))})
+	case "except":
+		steps = append(steps, plan.Step{Op: "except", Sub: compileGremlinPlan(firstChainArg(obj))})
+	case "back":
+		arg, _ := obj.Get("_gremlin_back_chain")
+		steps = append(steps, plan.Step{Op: "back", Sub: compileGremlinPlan(arg.Object())})
+	case "follow":
+		steps = append(steps, plan.Step{Op: "follow", Sub: compileGremlinPlan(firstChainArg(obj))})
+	case "followr":
+		arg, _ := obj.Get("_gremlin_followr")
+		steps = append(steps, plan.Step{Op: "follow", Sub: compileGremlinPlan(arg.Object())})
+	case "repeat":
+		steps = append(steps, compileRepeatPlan(obj))
+	}
+	return steps
+}
+
+// saveStep builds the Step for "save"/"saver": Save(via) tags the saved
+// node with via itself, Save(via, tag) tags it with tag instead.
+func saveStep(op string, stringArgs []string) plan.Step {
+	if len(stringArgs) == 0 || len(stringArgs) > 2 {
+		return plan.Step{Op: "null"}
+	}
+	return plan.Step{Op: op, Args: toArgs(stringArgs)}
+}
+
+func firstChainArg(obj *otto.Object) *otto.Object {
+	arg, _ := obj.Get("_gremlin_values")
+	firstArg, _ := arg.Object().Get("0")
+	return firstArg.Object()
+}
+
+func compileRepeatPlan(obj *otto.Object) plan.Step {
+	arg, _ := obj.Get("_gremlin_values")
+	argArray := arg.Object()
+	firstArg, _ := argArray.Get("0")
+
+	depth := 0
+	lengthVal, _ := argArray.Get("length")
+	length, _ := lengthVal.ToInteger()
+	if length >= 2 {
+		one, _ := argArray.Get("1")
+		n, _ := one.ToInteger()
+		depth = int(n)
+	}
+	return plan.Step{
+		Op:   "repeat",
+		Args: []interface{}{float64(depth)},
+		Sub:  compileGremlinPlan(firstArg.Object()),
+	}
+}
+
+func compileHasPlan(obj *otto.Object) plan.Step {
+	args := propertyAsArray(obj, "_gremlin_values")
+	if len(args) < 2 || len(args) > 3 {
+		return plan.Step{Op: "null"}
+	}
+	via := toArgs(convertOttoObjToStrArray(args[0]))
+
+	if len(args) == 3 {
+		opVal, _ := args[1].ToInteger()
+		var value interface{}
+		if args[2].Class() == "Array" {
+			if v, ok := convertOttoObjToIntArray(args[2]); ok {
+				value = plan.EncodeValue(v)
+			} else {
+				value = plan.EncodeValue(convertOttoObjToStrArray(args[2]))
+			}
+		} else if v, ok := convertOttoValueToTyped(args[2]); ok {
+			value = plan.EncodeValue(v)
+		}
+		return plan.Step{Op: "has_filter", Args: via, Object: []interface{}{float64(opVal), value}}
+	}
+
+	if args[1].Class() == "Object" && isVertexChain(args[1].Object()) {
+		return plan.Step{Op: "has_path", Args: via, Sub: compileGremlinPlan(args[1].Object())}
+	}
+	return plan.Step{Op: "has", Args: via, Object: toArgs(convertOttoObjToStrArray(args[1]))}
+}
+
+func toArgs(strs []string) []interface{} {
+	if len(strs) == 0 {
+		return nil
+	}
+	out := make([]interface{}, len(strs))
+	for i, s := range strs {
+		out[i] = s
+	}
+	return out
+}
@@ -0,0 +1,89 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package gremlin
+
+import (
+	"github.com/robertkrimen/otto"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/graph/iterator"
+)
+
+// AddPathExtensions installs the Count, GroupCount, Limit, Skip, and
+// OrderBy methods onto a Gremlin vertex or morphism prototype, so a
+// script like g.V().Out("follows").GroupCount("target") reaches the
+// matching _gremlin_type cases in compileGremlinPath / compileGremlinPlan
+// instead of failing with "undefined is not a function". NewSession
+// calls this on every prototype it installs the Gremlin environment
+// onto.
+func AddPathExtensions(proto *otto.Object) {
+	proto.Set("Count", newStepMethod("count"))
+	proto.Set("GroupCount", newStepMethod("group_count"))
+	proto.Set("Limit", newStepMethod("limit"))
+	proto.Set("Skip", newStepMethod("skip"))
+	proto.Set("OrderBy", newStepMethod("order"))
+}
+
+// AddRepeatExtension installs the Repeat method onto a Gremlin vertex or
+// morphism prototype, so g.V("x").Repeat(morphism, depth) reaches the
+// "repeat" case in compileGremlinPath / compileGremlinPlan instead of
+// failing with "undefined is not a function". NewSession calls this on
+// every prototype it installs the Gremlin environment onto.
+func AddRepeatExtension(proto *otto.Object) {
+	proto.Set("Repeat", newStepMethod("repeat"))
+}
+
+// newStepMethod returns an otto-callable that appends a single
+// gremlinType step onto the chain object it's called as a method of,
+// carrying the call's arguments the same way every other chain step
+// does: as _gremlin_values, with every string argument mirrored into
+// string_args for the steps that only care about names.
+func newStepMethod(gremlinType string) func(otto.FunctionCall) otto.Value {
+	return func(call otto.FunctionCall) otto.Value {
+		vm := call.Otto
+		step, _ := vm.Object(`({})`)
+		step.Set("_gremlin_type", gremlinType)
+		step.Set("_gremlin_prev", call.This)
+		step.Set("_gremlin_values", call.ArgumentList)
+		step.Set("string_args", stringArgsOf(call.ArgumentList))
+		return step.Value()
+	}
+}
+
+func stringArgsOf(args []otto.Value) []string {
+	var out []string
+	for _, a := range args {
+		if a.IsString() {
+			out = append(out, a.String())
+		}
+	}
+	return out
+}
+
+// formatResult turns an iterator result into the shape a session sends
+// back to the client. Count and GroupCount don't yield graph nodes, so
+// qs.NameOf can't turn their results into a name the way it can for
+// every other traversal -- Count's result is already the int64 to send,
+// and a GroupCountResult is reported as its name/count pair.
+func formatResult(qs graph.QuadStore, v graph.Value) interface{} {
+	switch r := v.(type) {
+	case int64:
+		return r
+	case iterator.GroupCountResult:
+		return map[string]interface{}{"id": r.Name, "count": r.Count}
+	default:
+		return qs.NameOf(v)
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright 2015 The Cayley Authors. All rights reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package plan gives a Gremlin traversal a JSON-serializable form,
+// independent of the otto VM that parsed it. query/gremlin compiles a
+// script's chain into a Plan once; the Plan can then be cached by script
+// hash, sent over the wire to a remote QuadStore worker, or replayed
+// against a QuadStore with Execute -- all without touching otto again.
+package plan
+
+import (
+	"time"
+
+	"github.com/google/cayley/graph"
+	"github.com/google/cayley/graph/iterator"
+	"github.com/google/cayley/graph/path"
+)
+
+// Step is a single operation of a compiled traversal. Args carries a
+// step's plain arguments (predicate names, tags, counts...); Object
+// carries has()'s object-side arguments, kept separate from Args so a
+// step never has to guess where one argument list ends and the other
+// begins; Sub carries a nested Plan for steps -- And, Or, Except, Back,
+// Follow, Repeat, has-with-a-sub-path -- whose argument is itself a
+// traversal.
+type Step struct {
+	Op     string        `json:"op"`
+	Args   []interface{} `json:"args,omitempty"`
+	Object []interface{} `json:"object,omitempty"`
+	Sub    Plan          `json:"sub,omitempty"`
+}
+
+// Plan is an ordered, JSON-serializable list of Steps.
+type Plan []Step
+
+// Execute compiles the Plan into a graph.Iterator and runs it against qs.
+func (p Plan) Execute(qs graph.QuadStore) graph.Iterator {
+	return p.toPath().BuildIteratorOn(qs)
+}
+
+func (p Plan) toPath() *path.Path {
+	cur := path.StartMorphism()
+	for _, step := range p {
+		cur = applyStep(cur, step)
+	}
+	return cur
+}
+
+func stringArgs(args []interface{}) []string {
+	out := make([]string, 0, len(args))
+	for _, a := range args {
+		if s, ok := a.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+func stringArg(args []interface{}) string {
+	if len(args) == 0 {
+		return ""
+	}
+	s, _ := args[0].(string)
+	return s
+}
+
+// typedValue carries a has_filter comparison value through JSON with its
+// Go type tagged explicitly. A bare interface{} doesn't survive a real
+// json.Marshal/Unmarshal round-trip -- int64 comes back as float64,
+// time.Time as a string, []int64 as []interface{} of float64 -- so
+// EncodeValue wraps the value with a type tag that DecodeValue uses to
+// reconstruct it, whether it's handed the original Go value (an
+// in-memory Plan that was never serialized) or the map[string]interface{}
+// shape json.Unmarshal produces for it.
+type typedValue struct {
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+// EncodeValue wraps a has_filter comparison value for JSON-safe storage
+// in a Step's Object. Types it doesn't recognize pass through unwrapped,
+// so a round-trip through JSON will hand them back as whatever plain type
+// encoding/json decodes them into.
+func EncodeValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case int64:
+		return typedValue{"int64", x}
+	case float64:
+		return typedValue{"float64", x}
+	case bool:
+		return typedValue{"bool", x}
+	case string:
+		return typedValue{"string", x}
+	case time.Time:
+		return typedValue{"time", x.Format(time.RFC3339Nano)}
+	case []int64:
+		return typedValue{"int64[]", x}
+	case []string:
+		return typedValue{"string[]", x}
+	default:
+		return v
+	}
+}
+
+// decodeValue reverses EncodeValue, accepting either the typedValue it
+// produced directly or the map[string]interface{} a JSON round-trip
+// turns it into.
+func decodeValue(v interface{}) graph.Value {
+	switch tv := v.(type) {
+	case typedValue:
+		return decodeTyped(tv.Type, tv.Value)
+	case map[string]interface{}:
+		typ, _ := tv["type"].(string)
+		return decodeTyped(typ, tv["value"])
+	default:
+		return v
+	}
+}
+
+func decodeTyped(typ string, raw interface{}) graph.Value {
+	switch typ {
+	case "int64":
+		switch n := raw.(type) {
+		case int64:
+			return n
+		case float64:
+			return int64(n)
+		}
+	case "float64":
+		switch n := raw.(type) {
+		case float64:
+			return n
+		case int64:
+			return float64(n)
+		}
+	case "bool":
+		b, _ := raw.(bool)
+		return b
+	case "string":
+		s, _ := raw.(string)
+		return s
+	case "time":
+		s, _ := raw.(string)
+		t, _ := time.Parse(time.RFC3339Nano, s)
+		return t
+	case "int64[]":
+		return decodeInt64Slice(raw)
+	case "string[]":
+		return decodeStringSlice(raw)
+	}
+	return nil
+}
+
+func decodeInt64Slice(raw interface{}) []int64 {
+	switch s := raw.(type) {
+	case []int64:
+		return s
+	case []interface{}:
+		out := make([]int64, len(s))
+		for i, v := range s {
+			if n, ok := v.(float64); ok {
+				out[i] = int64(n)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+func decodeStringSlice(raw interface{}) []string {
+	switch s := raw.(type) {
+	case []string:
+		return s
+	case []interface{}:
+		out := make([]string, len(s))
+		for i, v := range s {
+			out[i], _ = v.(string)
+		}
+		return out
+	}
+	return nil
+}
+
+// saveArgs pulls the via predicate and tag out of a save/saver Step's
+// Args -- Args[0] is via, and Args[1], if present, overrides via as the
+// tag; with no override, the tag defaults to via itself, matching
+// Save/SaveReverse's own single-argument form.
+func saveArgs(args []interface{}) (via, tag string) {
+	strs := stringArgs(args)
+	if len(strs) == 0 {
+		return "", ""
+	}
+	via = strs[0]
+	tag = via
+	if len(strs) > 1 {
+		tag = strs[1]
+	}
+	return via, tag
+}
+
+func numberArg(args []interface{}) float64 {
+	if len(args) == 0 {
+		return 0
+	}
+	switch n := args[0].(type) {
+	case float64:
+		return n
+	case int:
+		return float64(n)
+	case int64:
+		return float64(n)
+	default:
+		return 0
+	}
+}
+
+func applyStep(p *path.Path, s Step) *path.Path {
+	switch s.Op {
+	case "vertex":
+		return path.StartPath(stringArgs(s.Args)...)
+	case "tag":
+		return p.Tag(stringArgs(s.Args)...)
+	case "save":
+		via, tag := saveArgs(s.Args)
+		return p.Save(via, tag)
+	case "saver":
+		via, tag := saveArgs(s.Args)
+		return p.SaveReverse(via, tag)
+	case "out":
+		return p.Out(stringArgs(s.Args)...)
+	case "in":
+		return p.In(stringArgs(s.Args)...)
+	case "both":
+		return p.Both(stringArgs(s.Args)...)
+	case "in_predicates":
+		return p.InPredicates()
+	case "out_predicates":
+		return p.OutPredicates()
+	case "is":
+		return p.Is(stringArgs(s.Args)...)
+	case "has":
+		return p.Has(stringArgs(s.Args), stringArgs(s.Object)...)
+	case "has_path":
+		return p.HasPath(stringArgs(s.Args), s.Sub.toPath())
+	case "has_filter":
+		op := iterator.Operator(int64(numberArg(s.Object)))
+		var value graph.Value
+		if len(s.Object) > 1 {
+			value = decodeValue(s.Object[1])
+		}
+		return p.HasFilter(stringArgs(s.Args), op, value)
+	case "count":
+		return p.Count()
+	case "group_count":
+		return p.GroupCount(stringArg(s.Args))
+	case "limit":
+		return p.Limit(int64(numberArg(s.Args)))
+	case "skip":
+		return p.Skip(int64(numberArg(s.Args)))
+	case "order":
+		return p.OrderBy(stringArg(s.Args))
+	case "and":
+		return p.And(s.Sub.toPath())
+	case "or":
+		return p.Or(s.Sub.toPath())
+	case "except":
+		return p.Except(s.Sub.toPath())
+	case "back":
+		return p.Back(s.Sub.toPath())
+	case "follow":
+		return p.Follow(s.Sub.toPath())
+	case "repeat":
+		return p.Repeat(s.Sub.toPath(), int(numberArg(s.Args)))
+	case "null":
+		return path.Null()
+	}
+	return p
+}